@@ -0,0 +1,112 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"sync"
+
+	"github.com/disksing/unistore/rocksdb"
+)
+
+// RegionCompactionListener translates rocksdb.CompactedEvent/FlushEvent key ranges into
+// per-region bytes-written counters, so split-check and snapshot-generation scheduling can react
+// to compaction activity instead of waiting on raft log size alone.
+type RegionCompactionListener struct {
+	mu             sync.RWMutex
+	regionForKey   func(key []byte) (regionID uint64, ok bool)
+	onBytesWritten func(regionID uint64, bytes uint64)
+	written        map[uint64]uint64
+}
+
+// NewRegionCompactionListener returns a RegionCompactionListener that reports bytes via onBytesWritten.
+// Call SetRegionResolver once region routing is available to start resolving keys to regions.
+func NewRegionCompactionListener(onBytesWritten func(regionID uint64, bytes uint64)) *RegionCompactionListener {
+	return &RegionCompactionListener{
+		onBytesWritten: onBytesWritten,
+		written:        make(map[uint64]uint64),
+	}
+}
+
+// SetRegionResolver installs the function used to map a compacted/flushed key to its region.
+func (l *RegionCompactionListener) SetRegionResolver(resolve func(key []byte) (regionID uint64, ok bool)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.regionForKey = resolve
+}
+
+// OnCompacted implements rocksdb.CompactionListener.
+func (l *RegionCompactionListener) OnCompacted(ev rocksdb.CompactedEvent) {
+	l.addBytesWritten(ev.StartKey, ev.EndKey, uint64(ev.TotalOutputBytes))
+}
+
+// OnFlush implements rocksdb.CompactionListener.
+func (l *RegionCompactionListener) OnFlush(ev rocksdb.FlushEvent) {
+	l.addBytesWritten(ev.StartKey, ev.EndKey, ev.FileSize)
+}
+
+// OnTableFileCreated implements rocksdb.CompactionListener; table creation alone does not move
+// bytes between regions, so it is a no-op.
+func (l *RegionCompactionListener) OnTableFileCreated(rocksdb.TableProperties) {}
+
+// addBytesWritten attributes bytes to the region(s) spanned by [startKey, endKey]. If both bounds
+// resolve to the same region (the common case), that region gets the full count; if they resolve
+// to different regions, each gets half, since the exact per-region split within the range is not
+// known without reading the underlying keys.
+func (l *RegionCompactionListener) addBytesWritten(startKey, endKey []byte, bytes uint64) {
+	if bytes == 0 {
+		return
+	}
+	l.mu.RLock()
+	resolve := l.regionForKey
+	l.mu.RUnlock()
+	if resolve == nil {
+		return
+	}
+	startRegion, startOK := resolve(startKey)
+	endRegion, endOK := resolve(endKey)
+	if startOK && endOK && startRegion != endRegion {
+		l.credit(startRegion, bytes/2)
+		l.credit(endRegion, bytes-bytes/2)
+		return
+	}
+	if startOK {
+		l.credit(startRegion, bytes)
+	} else if endOK {
+		l.credit(endRegion, bytes)
+	}
+}
+
+func (l *RegionCompactionListener) credit(regionID uint64, bytes uint64) {
+	l.mu.Lock()
+	l.written[regionID] += bytes
+	total := l.written[regionID]
+	l.mu.Unlock()
+	if l.onBytesWritten != nil {
+		l.onBytesWritten(regionID, total)
+	}
+}
+
+// BytesWritten returns the accumulated bytes-written counter for a region since the last ResetBytesWritten.
+func (l *RegionCompactionListener) BytesWritten(regionID uint64) uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.written[regionID]
+}
+
+// ResetBytesWritten zeroes the bytes-written counter for a region.
+func (l *RegionCompactionListener) ResetBytesWritten(regionID uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.written, regionID)
+}
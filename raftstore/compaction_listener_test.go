@@ -0,0 +1,63 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"testing"
+
+	"github.com/disksing/unistore/rocksdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegionCompactionListenerAccumulatesPerRegion(t *testing.T) {
+	var notified []uint64
+	l := NewRegionCompactionListener(func(regionID uint64, bytes uint64) {
+		notified = append(notified, regionID)
+	})
+	l.SetRegionResolver(func(key []byte) (uint64, bool) {
+		if len(key) == 0 {
+			return 0, false
+		}
+		return uint64(key[0]), true
+	})
+
+	l.OnCompacted(rocksdb.CompactedEvent{StartKey: []byte{1}, TotalOutputBytes: 10})
+	l.OnFlush(rocksdb.FlushEvent{StartKey: []byte{1}, FileSize: 5})
+
+	require.EqualValues(t, 15, l.BytesWritten(1))
+	require.Equal(t, []uint64{1, 1}, notified)
+
+	l.ResetBytesWritten(1)
+	require.EqualValues(t, 0, l.BytesWritten(1))
+}
+
+func TestSnapManagerRegisterListenerOnEventBus(t *testing.T) {
+	dir := t.TempDir()
+	sm := new(SnapManagerBuilder).Build(dir, nil)
+	require.NotNil(t, sm.Events())
+
+	sm.SetRegionResolver(func(key []byte) (uint64, bool) { return 7, true })
+
+	// Publishing through the SnapManager's own bus must reach its registered
+	// RegionCompactionListener, proving the listener is actually wired up.
+	sm.Events().PublishCompacted(rocksdb.CompactedEvent{StartKey: []byte("x"), TotalOutputBytes: 10})
+	require.EqualValues(t, 10, sm.compactionListener.BytesWritten(7))
+
+	// Once bytes written exceed MergeRegionSize, onRegionBytesWritten resets the counter.
+	sm.Events().PublishCompacted(rocksdb.CompactedEvent{
+		StartKey:         []byte("x"),
+		TotalOutputBytes: int(sm.Config().MergeRegionSize),
+	})
+	require.EqualValues(t, 0, sm.compactionListener.BytesWritten(7))
+}
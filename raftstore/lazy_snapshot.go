@@ -0,0 +1,226 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/disksing/unistore/rocksdb"
+	"github.com/pingcap/log"
+)
+
+// lazyTable is a single SST file tracked by a LazySnapshot's manifest: its key range and parsed
+// index, and whether it has already been ingested into the KV engine.
+type lazyTable struct {
+	path        string
+	props       rocksdb.TableProperties
+	entries     []rocksdb.IndexEntry
+	ingested    int32
+	accessCount int32
+}
+
+// LazySnapshot implements the Snapshot interface on top of a manifest of the SST files a snapshot
+// received, without requiring them to be ingested into the KV engine first. It lets the raftstore
+// serve point reads for a region by opening only the table whose key range covers the query,
+// while a background goroutine ingests the remaining tables in priority order.
+type LazySnapshot struct {
+	inner Snapshot
+	sm    *SnapManager
+	key   SnapKey
+	cmp   rocksdb.Comparator
+
+	mu     sync.RWMutex
+	tables []*lazyTable
+
+	total     uint64
+	ingested  uint64
+	closeOnce sync.Once
+}
+
+// newLazySnapshot builds a LazySnapshot's manifest by reading the footer and index block of
+// every SST belonging to key in base.
+func newLazySnapshot(inner Snapshot, sm *SnapManager, base string, key SnapKey) (*LazySnapshot, error) {
+	paths, err := snapshotFiles(base, key)
+	if err != nil {
+		return nil, err
+	}
+	ls := &LazySnapshot{inner: inner, sm: sm, key: key, cmp: rocksdb.Comparator(bytes.Compare)}
+	for _, path := range paths {
+		if !strings.HasSuffix(path, sstFileSuffix) {
+			continue
+		}
+		props, entries, err := rocksdb.ReadTable(path)
+		if err != nil {
+			return nil, err
+		}
+		ls.tables = append(ls.tables, &lazyTable{path: path, props: props, entries: entries})
+	}
+	ls.total = uint64(len(ls.tables))
+	return ls, nil
+}
+
+// findTable returns the not-yet-ingested table whose range covers key, recording the lookup as an
+// access so StartBackgroundIngest can prioritize hot tables.
+func (ls *LazySnapshot) findTable(key []byte) *lazyTable {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	for _, t := range ls.tables {
+		if atomic.LoadInt32(&t.ingested) != 0 {
+			continue
+		}
+		if t.props.Contains(key, ls.cmp) {
+			atomic.AddInt32(&t.accessCount, 1)
+			return t
+		}
+	}
+	return nil
+}
+
+// Get looks up key among the tables that have not yet been ingested into the KV engine. It
+// returns ok=false both when no manifest table covers the key and when the key is simply absent,
+// so the caller should fall back to the KV engine either way once Done reports true.
+func (ls *LazySnapshot) Get(key []byte) (value []byte, ok bool, err error) {
+	t := ls.findTable(key)
+	if t == nil {
+		return nil, false, nil
+	}
+	idx := sort.Search(len(t.entries), func(i int) bool {
+		return ls.cmp(t.entries[i].Key, key) >= 0
+	})
+	if idx == len(t.entries) {
+		return nil, false, nil
+	}
+	block, err := rocksdb.ReadBlockAt(t.path, t.entries[idx].Offset, t.entries[idx].Size)
+	if err != nil {
+		return nil, false, err
+	}
+	dataEntries, err := rocksdb.ParseDataBlock(block)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, e := range dataEntries {
+		if ls.cmp(e.Key, key) == 0 {
+			return e.Value, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// MarkIngested evicts path from the lazy manifest once the background ingest goroutine has moved
+// it into the KV engine, so later reads go straight to the engine instead of the SST file.
+func (ls *LazySnapshot) MarkIngested(path string) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	for _, t := range ls.tables {
+		if t.path == path && atomic.CompareAndSwapInt32(&t.ingested, 0, 1) {
+			// Deregister before the last table is counted, so Done never reports true while the
+			// SnapEntryLazyApplying entry is still registered.
+			if atomic.LoadUint64(&ls.ingested)+1 == ls.total {
+				ls.Close()
+			}
+			atomic.AddUint64(&ls.ingested, 1)
+			return
+		}
+	}
+}
+
+// Progress reports how many of the snapshot's SST files have been ingested into the KV engine.
+func (ls *LazySnapshot) Progress() (ingested, total uint64) {
+	return atomic.LoadUint64(&ls.ingested), ls.total
+}
+
+// Done reports whether every table has been ingested.
+func (ls *LazySnapshot) Done() bool {
+	ingested, total := ls.Progress()
+	return ingested == total
+}
+
+// pickIngestCandidate returns the not-yet-ingested table with the most reads so far (access counts
+// are never reset), implementing "hot ranges first" prioritization.
+func (ls *LazySnapshot) pickIngestCandidate() *lazyTable {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	var best *lazyTable
+	bestAccess := int32(-1)
+	for _, t := range ls.tables {
+		if atomic.LoadInt32(&t.ingested) != 0 {
+			continue
+		}
+		if a := atomic.LoadInt32(&t.accessCount); a > bestAccess {
+			bestAccess = a
+			best = t
+		}
+	}
+	return best
+}
+
+// StartBackgroundIngest launches a goroutine that calls ingestOne for each remaining table in
+// priority order, marks it ingested on success, and closes the LazySnapshot (deregistering its
+// SnapEntryLazyApplying entry) once every table has been ingested.
+func (ls *LazySnapshot) StartBackgroundIngest(ingestOne func(path string, props rocksdb.TableProperties) error) {
+	go func() {
+		for !ls.Done() {
+			t := ls.pickIngestCandidate()
+			if t == nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			if err := ingestOne(t.path, t.props); err != nil {
+				log.S().Warnf("failed to ingest lazy snapshot table %s: %v", t.path, err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			ls.MarkIngested(t.path)
+		}
+		ls.Close()
+	}()
+}
+
+// Close deregisters the LazySnapshot's SnapEntryLazyApplying entry. It is idempotent and safe to
+// call both after StartBackgroundIngest finishes and from Delete, so a region destroyed mid-ingest
+// is correctly deregistered exactly once.
+func (ls *LazySnapshot) Close() {
+	ls.closeOnce.Do(func() {
+		ls.sm.Deregister(ls.key, SnapEntryLazyApplying)
+	})
+}
+
+// Meta delegates to the underlying snapshot.
+func (ls *LazySnapshot) Meta() (os.FileInfo, error) {
+	return ls.inner.Meta()
+}
+
+// Path delegates to the underlying snapshot.
+func (ls *LazySnapshot) Path() string {
+	return ls.inner.Path()
+}
+
+// Exists delegates to the underlying snapshot.
+func (ls *LazySnapshot) Exists() bool {
+	return ls.inner.Exists()
+}
+
+// Delete closes the LazySnapshot and deletes the underlying snapshot's files wholesale, whether or
+// not ingestion had completed. Tables already ingested into the KV engine are not removed from it,
+// and a goroutine started by StartBackgroundIngest is not stopped.
+func (ls *LazySnapshot) Delete() {
+	ls.Close()
+	ls.inner.Delete()
+}
@@ -0,0 +1,115 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/disksing/unistore/rocksdb"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSnapshot struct {
+	path    string
+	deleted bool
+}
+
+func (f *fakeSnapshot) Meta() (os.FileInfo, error) { return os.Stat(f.path) }
+func (f *fakeSnapshot) Path() string               { return f.path }
+func (f *fakeSnapshot) Exists() bool               { return !f.deleted }
+func (f *fakeSnapshot) Delete()                    { f.deleted = true }
+
+// writeLazyTestTable writes a single-data-block SST matching the naming pattern snapshotFiles
+// expects for key, using cf to tell multiple tables of the same key apart.
+func writeLazyTestTable(t *testing.T, dir string, key SnapKey, cf string, pairs [][2]string) string {
+	var dataBlock []byte
+	for _, p := range pairs {
+		dataBlock = append(dataBlock, rocksdb.EncodeDataEntry([]byte(p[0]), []byte(p[1]))...)
+	}
+	lastKey := pairs[len(pairs)-1][0]
+	indexBlock := rocksdb.EncodeIndexEntry([]byte(lastKey), 0, uint64(len(dataBlock)))
+
+	file := append([]byte{}, dataBlock...)
+	indexOffset := uint64(len(file))
+	file = append(file, indexBlock...)
+	file = append(file, rocksdb.EncodeTableFooter(indexOffset, uint64(len(indexBlock)))...)
+
+	name := fmt.Sprintf("gen_%s_%d_%d_%d%s", cf, key.RegionID, key.Term, key.Index, sstFileSuffix)
+	path := filepath.Join(dir, name)
+	require.Nil(t, ioutil.WriteFile(path, file, 0600))
+	return path
+}
+
+func TestLazySnapshotGetAndBackgroundIngest(t *testing.T) {
+	dir := t.TempDir()
+	key := SnapKey{RegionID: 1, Term: 1, Index: 1}
+	writeLazyTestTable(t, dir, key, "default", [][2]string{{"a", "1"}, {"b", "2"}})
+	writeLazyTestTable(t, dir, key, "write", [][2]string{{"x", "9"}, {"y", "8"}})
+
+	sm := new(SnapManagerBuilder).Build(dir, nil)
+	inner := &fakeSnapshot{path: filepath.Join(dir, "meta")}
+	ls, err := newLazySnapshot(inner, sm, dir, key)
+	require.Nil(t, err)
+
+	ingested, total := ls.Progress()
+	require.EqualValues(t, 0, ingested)
+	require.EqualValues(t, 2, total)
+
+	val, ok, err := ls.Get([]byte("b"))
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte("2"), val)
+
+	_, ok, err = ls.Get([]byte("nope"))
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	sm.Register(key, SnapEntryLazyApplying)
+	require.True(t, sm.HasRegistered(key))
+
+	var ingestedPaths []string
+	ls.StartBackgroundIngest(func(path string, props rocksdb.TableProperties) error {
+		ingestedPaths = append(ingestedPaths, path)
+		return nil
+	})
+
+	require.Eventually(t, ls.Done, 2*time.Second, time.Millisecond)
+	require.Len(t, ingestedPaths, 2)
+	require.False(t, sm.HasRegistered(key))
+
+	_, ok, err = ls.Get([]byte("b"))
+	require.Nil(t, err)
+	require.False(t, ok)
+}
+
+func TestLazySnapshotDeleteDeregisters(t *testing.T) {
+	dir := t.TempDir()
+	key := SnapKey{RegionID: 2, Term: 1, Index: 1}
+	writeLazyTestTable(t, dir, key, "default", [][2]string{{"a", "1"}})
+
+	sm := new(SnapManagerBuilder).Build(dir, nil)
+	inner := &fakeSnapshot{path: filepath.Join(dir, "meta")}
+	ls, err := newLazySnapshot(inner, sm, dir, key)
+	require.Nil(t, err)
+
+	sm.Register(key, SnapEntryLazyApplying)
+	ls.Delete()
+	require.False(t, sm.HasRegistered(key))
+	require.True(t, inner.deleted)
+}
@@ -0,0 +1,183 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/errors"
+	rspb "github.com/pingcap/kvproto/pkg/raft_serverpb"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+const tmpPartialSuffix = ".tmp.partial"
+
+// snapshotFiles returns every meta/sst file belonging to key in base, sorted for determinism.
+// In-progress .tmp.partial files are excluded, since a reader should never observe them.
+func snapshotFiles(base string, key SnapKey) ([]string, error) {
+	pattern := filepath.Join(base, fmt.Sprintf("*_%d_%d_%d.*", key.RegionID, key.Term, key.Index))
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	filtered := matches[:0]
+	for _, m := range matches {
+		if strings.HasSuffix(m, tmpPartialSuffix) {
+			continue
+		}
+		filtered = append(filtered, m)
+	}
+	sort.Strings(filtered)
+	return filtered, nil
+}
+
+// fileCRC32C streams path through a CRC32C hash in chunks and returns the checksum.
+func fileCRC32C(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer f.Close()
+	h := crc32.New(crc32cTable)
+	buf := make([]byte, 32*1024)
+	if _, err := io.CopyBuffer(h, f, buf); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return h.Sum32(), nil
+}
+
+// writeAtomicFile writes data to <dir>/<name>.tmp.partial, fsyncs it, fsyncs dir, then renames it
+// to <dir>/<name> and fsyncs dir again so the rename itself is durable. A crash before the rename leaves only the .tmp.partial file, which init()
+// already cleans up, so a reader never observes a half-written final file.
+func writeAtomicFile(dir, name string, data []byte) error {
+	tmpPath := filepath.Join(dir, name+tmpPartialSuffix)
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return errors.WithStack(err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	parent, err := os.Open(dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := parent.Sync(); err != nil {
+		parent.Close()
+		return errors.WithStack(err)
+	}
+	if err := parent.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	finalPath := filepath.Join(dir, name)
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		return errors.WithStack(err)
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer d.Close()
+	return errors.WithStack(d.Sync())
+}
+
+// ComputeSnapshotDigests computes the CRC32C digest of every file belonging to key, in the same
+// order snapshotFiles returns them. Whatever assembles RaftSnapshotData.Meta for the wire (outside
+// this package) must copy these into meta.CfFiles[i].Checksum in that same order, so the digest
+// actually travels with the snapshot instead of living in a file the receiver can never see.
+func ComputeSnapshotDigests(base string, key SnapKey) ([]uint32, error) {
+	files, err := snapshotFiles(base, key)
+	if err != nil {
+		return nil, err
+	}
+	digests := make([]uint32, len(files))
+	for i, path := range files {
+		crc, err := fileCRC32C(path)
+		if err != nil {
+			return nil, err
+		}
+		digests[i] = crc
+	}
+	return digests, nil
+}
+
+// digestsFromMeta extracts the per-file CRC32C digests a sender embedded in meta.CfFiles, in
+// file order, for VerifySnapshotFiles to check the receiver's files against.
+func digestsFromMeta(meta *rspb.SnapshotMeta) []uint32 {
+	if meta == nil {
+		return nil
+	}
+	digests := make([]uint32, len(meta.CfFiles))
+	for i, cf := range meta.CfFiles {
+		digests[i] = cf.Checksum
+	}
+	return digests
+}
+
+// VerifySnapshotFiles recomputes the CRC32C of every file belonging to key, in the same order
+// snapshotFiles returns them, and compares each against the corresponding entry of expected. A
+// length or checksum mismatch returns ErrSnapshotCorrupt. An empty expected is treated as "sender
+// predates digest verification" and passes without checking anything.
+func VerifySnapshotFiles(base string, key SnapKey, expected []uint32) error {
+	if len(expected) == 0 {
+		return nil
+	}
+	files, err := snapshotFiles(base, key)
+	if err != nil {
+		return err
+	}
+	if len(files) != len(expected) {
+		return errors.WithStack(ErrSnapshotCorrupt)
+	}
+	for i, path := range files {
+		got, err := fileCRC32C(path)
+		if err != nil {
+			return errors.WithStack(ErrSnapshotCorrupt)
+		}
+		if got != expected[i] {
+			return errors.WithStack(ErrSnapshotCorrupt)
+		}
+	}
+	return nil
+}
+
+// verifiedSnapshot wraps a Snapshot to add the Verify method the receiving path calls before
+// handing the snapshot to the applying goroutine.
+type verifiedSnapshot struct {
+	Snapshot
+	base     string
+	key      SnapKey
+	expected []uint32
+}
+
+// Verify recomputes and checks the CRC32C of every received file against the digests the sender
+// embedded in RaftSnapshotData.Meta.
+func (v *verifiedSnapshot) Verify() error {
+	return VerifySnapshotFiles(v.base, v.key, v.expected)
+}
@@ -0,0 +1,87 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap/errors"
+	rspb "github.com/pingcap/kvproto/pkg/raft_serverpb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAtomicFileLeavesNoPartial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snap-integrity")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Nil(t, writeAtomicFile(dir, "foo", []byte("hello")))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "foo"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("hello"), data)
+
+	_, err = os.Stat(filepath.Join(dir, "foo"+tmpPartialSuffix))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestComputeSnapshotDigestsExcludesTmpPartial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snap-integrity")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	key := SnapKey{RegionID: 1, Term: 2, Index: 3}
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "gen_1_2_3.sst"), []byte("payload"), 0600))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "gen_1_2_3.sst"+tmpPartialSuffix), []byte("partial"), 0600))
+
+	digests, err := ComputeSnapshotDigests(dir, key)
+	require.Nil(t, err)
+	require.Len(t, digests, 1)
+}
+
+func TestVerifySnapshotFilesDetectsCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snap-integrity")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	key := SnapKey{RegionID: 1, Term: 2, Index: 3}
+	sstPath := filepath.Join(dir, "gen_1_2_3.sst")
+	require.Nil(t, ioutil.WriteFile(sstPath, []byte("payload"), 0600))
+
+	expected, err := ComputeSnapshotDigests(dir, key)
+	require.Nil(t, err)
+	require.Nil(t, VerifySnapshotFiles(dir, key, expected))
+
+	require.Nil(t, ioutil.WriteFile(sstPath, []byte("corrupted"), 0600))
+	require.Equal(t, ErrSnapshotCorrupt, errors.Cause(VerifySnapshotFiles(dir, key, expected)))
+}
+
+func TestVerifySnapshotFilesPassesWithoutExpectedDigests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snap-integrity")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Nil(t, VerifySnapshotFiles(dir, SnapKey{RegionID: 1, Term: 1, Index: 1}, nil))
+}
+
+func TestDigestsFromMeta(t *testing.T) {
+	meta := &rspb.SnapshotMeta{CfFiles: []*rspb.SnapshotCFFile{
+		{Cf: "default", Checksum: 111},
+		{Cf: "write", Checksum: 222},
+	}}
+	require.Equal(t, []uint32{111, 222}, digestsFromMeta(meta))
+	require.Nil(t, digestsFromMeta(nil))
+}
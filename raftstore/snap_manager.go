@@ -25,6 +25,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/disksing/unistore/rocksdb"
 	"github.com/pingcap/errors"
 	rspb "github.com/pingcap/kvproto/pkg/raft_serverpb"
 	"github.com/pingcap/log"
@@ -39,6 +40,10 @@ const (
 	SnapEntrySending    SnapEntry = 2
 	SnapEntryReceiving  SnapEntry = 3
 	SnapEntryApplying   SnapEntry = 4
+	// SnapEntryVerifying marks a snapshot undergoing CRC32C revalidation before it is applied.
+	SnapEntryVerifying SnapEntry = 5
+	// SnapEntryLazyApplying marks a snapshot being served from a LazySnapshot while ingest runs in the background.
+	SnapEntryLazyApplying SnapEntry = 6
 )
 
 // String returns a string representation of the snapshot entry.	`
@@ -52,14 +57,51 @@ func (e SnapEntry) String() string {
 		return "receiving"
 	case SnapEntryApplying:
 		return "applying"
+	case SnapEntryVerifying:
+		return "verifying"
+	case SnapEntryLazyApplying:
+		return "lazy_applying"
 	}
 	return "unknown"
 }
 
+// ErrSnapshotCorrupt is returned when a received snapshot file fails its CRC32C integrity check.
+var ErrSnapshotCorrupt = errors.New("snapshot corrupt")
+
 // SnapStats represents a snapshot stats.
 type SnapStats struct {
 	ReceivingCount int
 	SendingCount   int
+	// IngestBytesTotal is the cumulative snap_ingest_bytes_total counter.
+	IngestBytesTotal uint64
+	// IngestThrottledSeconds is the cumulative snap_ingest_throttled_seconds counter. It sums the
+	// full duration of each counted NewSnapForBuilding/NewSnapForReceiving call, not only the time
+	// spent waiting on the rate limiter.
+	IngestThrottledSeconds float64
+}
+
+// SnapManagerConfig is the SnapManager's dynamically reloadable ingest configuration.
+type SnapManagerConfig struct {
+	// IngestGoroutines bounds how many GetSnapshotForBuilding/GetSnapshotForReceiving calls run
+	// NewSnapForBuilding/NewSnapForReceiving at once; the slot is released when that call returns.
+	IngestGoroutines int
+	// IngestBytesPerSec caps the aggregate ingest throughput. Zero means unlimited.
+	IngestBytesPerSec int64
+	// MergeRegionSize is the number of compaction/flush bytes a region accumulates before
+	// onRegionBytesWritten notifies the store and resets the region's counter. Zero disables this.
+	MergeRegionSize uint64
+	// MergeRegionKeyCount is carried in the config only; nothing in this package reads it.
+	MergeRegionKeyCount uint64
+}
+
+// DefaultSnapManagerConfig returns the config used when a SnapManagerBuilder is not given one.
+func DefaultSnapManagerConfig() SnapManagerConfig {
+	return SnapManagerConfig{
+		IngestGoroutines:    1,
+		IngestBytesPerSec:   0,
+		MergeRegionSize:     96 * 1024 * 1024,
+		MergeRegionKeyCount: 960000,
+	}
 }
 
 func notifyStats(router *router) {
@@ -75,8 +117,19 @@ type SnapManager struct {
 	registryLock sync.RWMutex
 	registry     map[SnapKey][]SnapEntry
 	router       *router
-	limiter      *IOLimiter
 	MaxTotalSize uint64
+
+	cfgLock   sync.RWMutex
+	cfg       SnapManagerConfig
+	limiter   *IOLimiter
+	ingestSem chan struct{}
+	paused    int32
+
+	ingestBytesTotal       uint64
+	ingestThrottledSeconds int64 // nanoseconds, read/written via atomic
+
+	events             *rocksdb.EventBus
+	compactionListener *RegionCompactionListener
 }
 
 // NewSnapManager returns a new SnapManager.
@@ -193,7 +246,9 @@ func (sm *SnapManager) GetTotalSnapSize() uint64 {
 	return uint64(atomic.LoadInt64(sm.snapSize))
 }
 
-// GetSnapshotForBuilding gets the snapshot for building with the given snapshot key.
+// GetSnapshotForBuilding gets the snapshot for building with the given snapshot key. Building is
+// bounded by the same ingest worker pool and rate limiter as receiving, since both write SST-sized
+// amounts of data to the same disk.
 func (sm *SnapManager) GetSnapshotForBuilding(key SnapKey) (Snapshot, error) {
 	if sm.GetTotalSnapSize() > sm.MaxTotalSize {
 		err := sm.deleteOldIdleSnaps()
@@ -201,7 +256,19 @@ func (sm *SnapManager) GetSnapshotForBuilding(key SnapKey) (Snapshot, error) {
 			return nil, err
 		}
 	}
-	return NewSnapForBuilding(sm.base, key, sm.snapSize, sm, sm.limiter)
+	slot := sm.AcquireIngestSlot()
+	sizeBefore := sm.GetTotalSnapSize()
+	start := time.Now()
+	snap, err := NewSnapForBuilding(sm.base, key, sm.snapSize, sm, sm.limiter)
+	throttled := time.Since(start)
+	sm.ReleaseIngestSlot(slot)
+	if err != nil {
+		return nil, err
+	}
+	if sizeAfter := sm.GetTotalSnapSize(); sizeAfter > sizeBefore {
+		sm.recordIngest(sizeAfter-sizeBefore, throttled)
+	}
+	return snap, nil
 }
 
 func (sm *SnapManager) deleteOldIdleSnaps() error {
@@ -243,19 +310,51 @@ func (sm *SnapManager) deleteOldIdleSnaps() error {
 	return nil
 }
 
-// GetSnapshotForSending gets the snapshot for sending with the given snapshot key.
+// GetSnapshotForSending gets the snapshot for sending with the given snapshot key. It also
+// confirms every file is currently readable and hashes cleanly, so an I/O error surfaces here
+// instead of mid-transfer; the caller that assembles RaftSnapshotData.Meta for the wire is
+// responsible for copying ComputeSnapshotDigests' result into meta.CfFiles[i].Checksum.
 func (sm *SnapManager) GetSnapshotForSending(snapKey SnapKey) (Snapshot, error) {
-	return NewSnapForSending(sm.base, snapKey, sm.snapSize, sm)
+	snap, err := NewSnapForSending(sm.base, snapKey, sm.snapSize, sm)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ComputeSnapshotDigests(sm.base, snapKey); err != nil {
+		return nil, err
+	}
+	return snap, nil
 }
 
 // GetSnapshotForReceiving gets the snapshot for receiving with the given snapshot key and data.
+// The returned snapshot's Verify checks every received file against the CRC32C digests the
+// sender embedded in snapshotData.Meta.CfFiles, which travelled here inside data.
 func (sm *SnapManager) GetSnapshotForReceiving(snapKey SnapKey, data []byte) (Snapshot, error) {
 	snapshotData := new(rspb.RaftSnapshotData)
 	err := snapshotData.Unmarshal(data)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return NewSnapForReceiving(sm.base, snapKey, snapshotData.Meta, sm.snapSize, sm, sm.limiter)
+	slot := sm.AcquireIngestSlot()
+	sizeBefore := sm.GetTotalSnapSize()
+	start := time.Now()
+	snap, err := NewSnapForReceiving(sm.base, snapKey, snapshotData.Meta, sm.snapSize, sm, sm.limiter)
+	throttled := time.Since(start)
+	sm.ReleaseIngestSlot(slot)
+	if err != nil {
+		return nil, err
+	}
+	if sizeAfter := sm.GetTotalSnapSize(); sizeAfter > sizeBefore {
+		sm.recordIngest(sizeAfter-sizeBefore, throttled)
+	}
+	verified := &verifiedSnapshot{Snapshot: snap, base: sm.base, key: snapKey, expected: digestsFromMeta(snapshotData.Meta)}
+	sm.Register(snapKey, SnapEntryVerifying)
+	defer sm.Deregister(snapKey, SnapEntryVerifying)
+	if err := verified.Verify(); err != nil {
+		log.S().Warnf("snapshot %s failed verification: %v", snapKey, err)
+		verified.Delete()
+		return nil, errors.WithStack(ErrSnapshotCorrupt)
+	}
+	return verified, nil
 }
 
 // GetSnapshotForApplying gets the snapshot for applying with the given snapshot key.
@@ -270,6 +369,25 @@ func (sm *SnapManager) GetSnapshotForApplying(snapKey SnapKey) (Snapshot, error)
 	return snap, nil
 }
 
+// GetSnapshotForLazyApplying returns a LazySnapshot for the given snapshot key, backed by a
+// range-indexed manifest of the already-received SST files, without waiting for them to be
+// ingested into the KV engine.
+func (sm *SnapManager) GetSnapshotForLazyApplying(key SnapKey) (*LazySnapshot, error) {
+	snap, err := NewSnapForApplying(sm.base, key, sm.snapSize, sm)
+	if err != nil {
+		return nil, err
+	}
+	if !snap.Exists() {
+		return nil, errors.Errorf("snapshot of %s not exists", key)
+	}
+	lazy, err := newLazySnapshot(snap, sm, sm.base, key)
+	if err != nil {
+		return nil, err
+	}
+	sm.Register(key, SnapEntryLazyApplying)
+	return lazy, nil
+}
+
 // Register registers a snapshot entry with the given snapshot key.
 func (sm *SnapManager) Register(key SnapKey, entry SnapEntry) {
 	log.S().Debugf("register key:%s, entry:%d", key, entry)
@@ -339,7 +457,110 @@ func (sm *SnapManager) Stats() SnapStats {
 			receivingCount++
 		}
 	}
-	return SnapStats{SendingCount: sendingCount, ReceivingCount: receivingCount}
+	return SnapStats{
+		SendingCount:           sendingCount,
+		ReceivingCount:         receivingCount,
+		IngestBytesTotal:       atomic.LoadUint64(&sm.ingestBytesTotal),
+		IngestThrottledSeconds: time.Duration(atomic.LoadInt64(&sm.ingestThrottledSeconds)).Seconds(),
+	}
+}
+
+// Events returns the SnapManager's EventBus, which its own RegionCompactionListener is always
+// registered on. Callers that drive compaction/flush notifications (e.g. the KV engine wrapper)
+// should publish to this bus.
+func (sm *SnapManager) Events() *rocksdb.EventBus {
+	return sm.events
+}
+
+// SetRegionResolver installs the key-to-region lookup used to attribute compaction/flush bytes
+// to a region.
+func (sm *SnapManager) SetRegionResolver(resolve func(key []byte) (regionID uint64, ok bool)) {
+	sm.compactionListener.SetRegionResolver(resolve)
+}
+
+// onRegionBytesWritten is called by the RegionCompactionListener each time more bytes have been
+// compacted or flushed for a region. Once a region's accumulated bytes reach MergeRegionSize, it
+// logs the region, sends the store a snapshot-stats notification, and resets the counter. The
+// notification does not name the region, and no split check or snapshot generation is scheduled here.
+func (sm *SnapManager) onRegionBytesWritten(regionID uint64, bytes uint64) {
+	if sm.Config().MergeRegionSize == 0 || bytes < sm.Config().MergeRegionSize {
+		return
+	}
+	log.S().Infof("region %d has %d bytes written by compaction, exceeding merge region size", regionID, bytes)
+	notifyStats(sm.router)
+	sm.compactionListener.ResetBytesWritten(regionID)
+}
+
+// SetConfig reloads the SnapManager's ingest configuration at runtime, resizing the ingest
+// worker pool and adjusting the shared rate limiter without restarting the process.
+func (sm *SnapManager) SetConfig(cfg SnapManagerConfig) {
+	sm.cfgLock.Lock()
+	defer sm.cfgLock.Unlock()
+	sm.cfg = cfg
+	if cfg.IngestBytesPerSec > 0 {
+		sm.limiter = NewIOLimiter(cfg.IngestBytesPerSec)
+	} else {
+		sm.limiter = NewInfLimiter()
+	}
+	goroutines := cfg.IngestGoroutines
+	if goroutines <= 0 {
+		goroutines = 1
+	}
+	sm.ingestSem = make(chan struct{}, goroutines)
+}
+
+// Config returns the SnapManager's current ingest configuration.
+func (sm *SnapManager) Config() SnapManagerConfig {
+	sm.cfgLock.RLock()
+	defer sm.cfgLock.RUnlock()
+	return sm.cfg
+}
+
+// PauseIngest quiesces snapshot ingest: AcquireIngestSlot blocks until ResumeIngest is called.
+func (sm *SnapManager) PauseIngest() {
+	atomic.StoreInt32(&sm.paused, 1)
+}
+
+// ResumeIngest undoes a prior PauseIngest.
+func (sm *SnapManager) ResumeIngest() {
+	atomic.StoreInt32(&sm.paused, 0)
+}
+
+// IngestPaused reports whether PauseIngest is currently in effect.
+func (sm *SnapManager) IngestPaused() bool {
+	return atomic.LoadInt32(&sm.paused) != 0
+}
+
+// IngestSlot is a token returned by AcquireIngestSlot and consumed by ReleaseIngestSlot. It pins
+// the exact worker-pool channel the slot was taken from, so a concurrent SetConfig swapping in a
+// new pool cannot cause the release to block on, or steal a slot from, the wrong generation.
+type IngestSlot struct {
+	sem chan struct{}
+}
+
+// AcquireIngestSlot blocks until both the per-store ingest worker pool has a free slot and
+// PauseIngest is not in effect, then reserves the slot and returns a token to release it.
+func (sm *SnapManager) AcquireIngestSlot() *IngestSlot {
+	for sm.IngestPaused() {
+		time.Sleep(100 * time.Millisecond)
+	}
+	sm.cfgLock.RLock()
+	sem := sm.ingestSem
+	sm.cfgLock.RUnlock()
+	sem <- struct{}{}
+	return &IngestSlot{sem: sem}
+}
+
+// ReleaseIngestSlot releases a slot acquired by AcquireIngestSlot.
+func (sm *SnapManager) ReleaseIngestSlot(slot *IngestSlot) {
+	<-slot.sem
+}
+
+// recordIngest accounts a completed ingest of n bytes that took throttled in total, measured
+// around the whole NewSnapForBuilding/NewSnapForReceiving call rather than the limiter wait alone.
+func (sm *SnapManager) recordIngest(n uint64, throttled time.Duration) {
+	atomic.AddUint64(&sm.ingestBytesTotal, n)
+	atomic.AddInt64(&sm.ingestThrottledSeconds, int64(throttled))
 }
 
 // DeleteSnapshot deletes a snapshot.
@@ -365,6 +586,7 @@ func (sm *SnapManager) DeleteSnapshot(key SnapKey, snapshot Snapshot, checkEntry
 // SnapManagerBuilder represents a snapshot manager builder.
 type SnapManagerBuilder struct {
 	maxTotalSize uint64
+	cfg          SnapManagerConfig
 }
 
 // MaxTotalSize returns the max total size of the SnapManagerBuilder.
@@ -373,18 +595,32 @@ func (smb *SnapManagerBuilder) MaxTotalSize(v uint64) *SnapManagerBuilder {
 	return smb
 }
 
+// Config sets the initial ingest configuration of the SnapManagerBuilder.
+func (smb *SnapManagerBuilder) Config(cfg SnapManagerConfig) *SnapManagerBuilder {
+	smb.cfg = cfg
+	return smb
+}
+
 // Build builds a router with the given path.
 func (smb *SnapManagerBuilder) Build(path string, router *router) *SnapManager {
 	var maxTotalSize uint64 = math.MaxUint64
 	if smb.maxTotalSize > 0 {
 		maxTotalSize = smb.maxTotalSize
 	}
-	return &SnapManager{
+	cfg := smb.cfg
+	if cfg == (SnapManagerConfig{}) {
+		cfg = DefaultSnapManagerConfig()
+	}
+	sm := &SnapManager{
 		base:         path,
 		snapSize:     new(int64),
 		registry:     map[SnapKey][]SnapEntry{},
 		router:       router,
-		limiter:      NewInfLimiter(),
 		MaxTotalSize: maxTotalSize,
 	}
+	sm.SetConfig(cfg)
+	sm.events = rocksdb.NewEventBus()
+	sm.compactionListener = NewRegionCompactionListener(sm.onRegionBytesWritten)
+	sm.events.RegisterListener(sm.compactionListener)
+	return sm
 }
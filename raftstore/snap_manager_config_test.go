@@ -0,0 +1,71 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapManagerSetConfigResizesIngestPool(t *testing.T) {
+	sm := new(SnapManagerBuilder).Build(t.TempDir(), nil)
+	require.Equal(t, 1, cap(sm.ingestSem))
+
+	sm.SetConfig(SnapManagerConfig{IngestGoroutines: 4})
+	require.Equal(t, 4, cap(sm.ingestSem))
+}
+
+// TestIngestSlotSurvivesConcurrentSetConfig reproduces the scenario where SetConfig swaps in a
+// new worker-pool channel between a paired Acquire/Release: since the slot token pins the channel
+// it was acquired from, Release must always target that same channel rather than racing with
+// whatever sm.ingestSem currently points to.
+func TestIngestSlotSurvivesConcurrentSetConfig(t *testing.T) {
+	sm := new(SnapManagerBuilder).Build(t.TempDir(), nil)
+	sm.SetConfig(SnapManagerConfig{IngestGoroutines: 1})
+
+	slot := sm.AcquireIngestSlot()
+
+	done := make(chan struct{})
+	go func() {
+		sm.SetConfig(SnapManagerConfig{IngestGoroutines: 1})
+		close(done)
+	}()
+	<-done
+
+	released := make(chan struct{})
+	go func() {
+		sm.ReleaseIngestSlot(slot)
+		close(released)
+	}()
+
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("ReleaseIngestSlot deadlocked after a concurrent SetConfig")
+	}
+
+	// The slot released above must not have been returned to the new generation's channel: a
+	// fresh Acquire/Release pair against the current pool should still work on its own.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s := sm.AcquireIngestSlot()
+		sm.ReleaseIngestSlot(s)
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,140 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocksdb
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/pingcap/log"
+)
+
+// FlushEvent represents a memtable flush.
+type FlushEvent struct {
+	ColumnFamilyName string
+	FileSize         uint64
+	StartKey         []byte
+	EndKey           []byte
+}
+
+// CompactionListener observes compaction, flush and table-creation events. Implementations must
+// not block, since EventBus calls every registered listener synchronously.
+type CompactionListener interface {
+	OnCompacted(ev CompactedEvent)
+	OnFlush(ev FlushEvent)
+	OnTableFileCreated(props TableProperties)
+}
+
+// EventBus fans CompactedEvent/FlushEvent/TableProperties notifications out to every registered
+// CompactionListener.
+type EventBus struct {
+	mu        sync.RWMutex
+	listeners map[CompactionListener]struct{}
+}
+
+// NewEventBus returns an empty EventBus.
+func NewEventBus() *EventBus {
+	return &EventBus{listeners: make(map[CompactionListener]struct{})}
+}
+
+// RegisterListener adds l to the set of listeners notified by future events. Registering the
+// same listener twice is a no-op.
+func (b *EventBus) RegisterListener(l CompactionListener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners[l] = struct{}{}
+}
+
+// UnregisterListener removes l from the set of listeners. It is a no-op if l was never registered.
+func (b *EventBus) UnregisterListener(l CompactionListener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.listeners, l)
+}
+
+// PublishCompacted fans ev out to every registered listener's OnCompacted.
+func (b *EventBus) PublishCompacted(ev CompactedEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for l := range b.listeners {
+		l.OnCompacted(ev)
+	}
+}
+
+// PublishFlush fans ev out to every registered listener's OnFlush.
+func (b *EventBus) PublishFlush(ev FlushEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for l := range b.listeners {
+		l.OnFlush(ev)
+	}
+}
+
+// PublishTableFileCreated fans props out to every registered listener's
+// OnTableFileCreated.
+func (b *EventBus) PublishTableFileCreated(props TableProperties) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for l := range b.listeners {
+		l.OnTableFileCreated(props)
+	}
+}
+
+// jsonEventSink is a CompactionListener that JSON-encodes every event it receives onto a Unix
+// domain socket it dials, so an operator listening on that socket (e.g. `nc -lU`) can tail
+// compactions.
+type jsonEventSink struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewJSONEventSink dials the Unix socket at addr and returns a CompactionListener that
+// JSON-encodes every event it receives onto that connection. The caller must register the
+// returned listener on an EventBus and Close it when done.
+func NewJSONEventSink(addr string) (*jsonEventSink, error) {
+	conn, err := net.Dial("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonEventSink{conn: conn}, nil
+}
+
+// Close closes the sink's underlying connection.
+func (s *jsonEventSink) Close() error {
+	return s.conn.Close()
+}
+
+func (s *jsonEventSink) write(kind string, payload interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	enc := json.NewEncoder(s.conn)
+	if err := enc.Encode(struct {
+		Kind  string      `json:"kind"`
+		Event interface{} `json:"event"`
+	}{Kind: kind, Event: payload}); err != nil {
+		log.S().Warnf("failed to write event to sink: %v", err)
+	}
+}
+
+// OnCompacted implements CompactionListener.
+func (s *jsonEventSink) OnCompacted(ev CompactedEvent) { s.write("compacted", ev) }
+
+// OnFlush implements CompactionListener.
+func (s *jsonEventSink) OnFlush(ev FlushEvent) { s.write("flush", ev) }
+
+// OnTableFileCreated implements CompactionListener.
+func (s *jsonEventSink) OnTableFileCreated(props TableProperties) {
+	s.write("table_file_created", props)
+}
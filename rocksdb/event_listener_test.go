@@ -0,0 +1,61 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocksdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingListener struct {
+	compacted []CompactedEvent
+	flushed   []FlushEvent
+	created   []TableProperties
+}
+
+func (r *recordingListener) OnCompacted(ev CompactedEvent) { r.compacted = append(r.compacted, ev) }
+func (r *recordingListener) OnFlush(ev FlushEvent)         { r.flushed = append(r.flushed, ev) }
+func (r *recordingListener) OnTableFileCreated(p TableProperties) {
+	r.created = append(r.created, p)
+}
+
+func TestEventBusFansOutToAllListeners(t *testing.T) {
+	bus := NewEventBus()
+	a := &recordingListener{}
+	b := &recordingListener{}
+	bus.RegisterListener(a)
+	bus.RegisterListener(b)
+
+	bus.PublishCompacted(CompactedEvent{StartKey: []byte("a")})
+	bus.PublishFlush(FlushEvent{StartKey: []byte("b")})
+	bus.PublishTableFileCreated(TableProperties{DataSize: 1})
+
+	require.Len(t, a.compacted, 1)
+	require.Len(t, a.flushed, 1)
+	require.Len(t, a.created, 1)
+	require.Len(t, b.compacted, 1)
+	require.Len(t, b.flushed, 1)
+	require.Len(t, b.created, 1)
+}
+
+func TestEventBusUnregisterStopsDelivery(t *testing.T) {
+	bus := NewEventBus()
+	l := &recordingListener{}
+	bus.RegisterListener(l)
+	bus.UnregisterListener(l)
+
+	bus.PublishCompacted(CompactedEvent{StartKey: []byte("a")})
+	require.Len(t, l.compacted, 0)
+}
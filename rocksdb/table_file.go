@@ -0,0 +1,155 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocksdb
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+
+	"github.com/pingcap/errors"
+)
+
+// tableFooterSize is the size, in bytes, of the fixed footer this package writes at the end of
+// every SST: the index block's offset and size, as two big-endian uint64s.
+const tableFooterSize = 16
+
+// errCorruptTableFooter is returned by ReadTable when a file is too short to contain a footer, or
+// the footer's index block handle falls outside the file.
+var errCorruptTableFooter = errors.New("corrupt table footer")
+
+// ReadTable reads an SST's footer and index block, returning the TableProperties (with
+// SmallestKey/LargestKey populated from the index) and the parsed index entries used to locate
+// each data block.
+func ReadTable(path string) (TableProperties, []IndexEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return TableProperties{}, nil, errors.WithStack(err)
+	}
+	if len(data) < tableFooterSize {
+		return TableProperties{}, nil, errCorruptTableFooter
+	}
+	footer := data[len(data)-tableFooterSize:]
+	indexOffset := rocksEndian.Uint64(footer[:8])
+	indexSize := rocksEndian.Uint64(footer[8:])
+	if indexOffset+indexSize > uint64(len(data)-tableFooterSize) {
+		return TableProperties{}, nil, errCorruptTableFooter
+	}
+	entries, err := ParseIndexBlock(data[indexOffset : indexOffset+indexSize])
+	if err != nil {
+		return TableProperties{}, nil, err
+	}
+	props := TableProperties{
+		DataSize:      indexOffset,
+		IndexSize:     indexSize,
+		NumDataBlocks: uint64(len(entries)),
+	}
+	if len(entries) > 0 {
+		// Each index entry stores the *last* key of the data block it points at, so the table's
+		// smallest key is the first key of the first data block, not the first index entry's key.
+		first := entries[0]
+		if first.Offset+first.Size > indexOffset {
+			return TableProperties{}, nil, errCorruptTableFooter
+		}
+		firstBlock, err := ParseDataBlock(data[first.Offset : first.Offset+first.Size])
+		if err != nil {
+			return TableProperties{}, nil, err
+		}
+		if len(firstBlock) == 0 {
+			return TableProperties{}, nil, errCorruptTableFooter
+		}
+		props.SmallestKey = firstBlock[0].Key
+		props.LargestKey = entries[len(entries)-1].Key
+	}
+	return props, entries, nil
+}
+
+// ReadBlockAt reads the byte range [offset, offset+size) of the SST at path, e.g. the data block
+// pointed at by an IndexEntry.
+func ReadBlockAt(path string, offset, size uint64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, int64(offset)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf, nil
+}
+
+// EncodeTableFooter encodes the fixed footer ReadTable expects: the index block's offset and size.
+func EncodeTableFooter(indexOffset, indexSize uint64) []byte {
+	footer := make([]byte, tableFooterSize)
+	rocksEndian.PutUint64(footer[:8], indexOffset)
+	rocksEndian.PutUint64(footer[8:], indexSize)
+	return footer
+}
+
+// EncodeIndexEntry encodes a single index-block entry in the format ParseIndexBlock expects.
+func EncodeIndexEntry(key []byte, offset, size uint64) []byte {
+	buf := encodeVarint64(make([]byte, binary.MaxVarintLen64), uint64(len(key)))
+	out := append([]byte{}, buf...)
+	out = append(out, key...)
+	h := blockHandle{Offset: offset, Size: size}
+	return append(out, h.Encode()...)
+}
+
+// EncodeDataEntry encodes a single key/value pair in the format ParseDataBlock expects.
+func EncodeDataEntry(key, value []byte) []byte {
+	keyBuf := encodeVarint64(make([]byte, binary.MaxVarintLen64), uint64(len(key)))
+	out := append([]byte{}, keyBuf...)
+	out = append(out, key...)
+	valBuf := encodeVarint64(make([]byte, binary.MaxVarintLen64), uint64(len(value)))
+	out = append(out, valBuf...)
+	out = append(out, value...)
+	return out
+}
+
+// DataEntry is a single key/value pair parsed out of a data block.
+type DataEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+// ParseDataBlock parses the restart-point-free, fully unpacked entries of an SST data block: each
+// entry is a varint-prefixed key followed by a varint-prefixed value.
+func ParseDataBlock(data []byte) ([]DataEntry, error) {
+	var entries []DataEntry
+	for off := 0; off < len(data); {
+		keyLen, n := decodeVarint64(data[off:])
+		if n == 0 {
+			return nil, errInvalidIndexBlock
+		}
+		off += n
+		if off+int(keyLen) > len(data) {
+			return nil, errInvalidIndexBlock
+		}
+		key := data[off : off+int(keyLen)]
+		off += int(keyLen)
+		valLen, n := decodeVarint64(data[off:])
+		if n == 0 {
+			return nil, errInvalidIndexBlock
+		}
+		off += n
+		if off+int(valLen) > len(data) {
+			return nil, errInvalidIndexBlock
+		}
+		val := data[off : off+int(valLen)]
+		off += int(valLen)
+		entries = append(entries, DataEntry{Key: key, Value: val})
+	}
+	return entries, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocksdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// buildTable hand-assembles a table file in this package's own (simplified, non-RocksDB-wire)
+// format: a single data block of key/value pairs, an index block with one entry pointing at it,
+// and the fixed footer giving the index block's offset/size.
+func buildTable(t *testing.T, dir string, pairs [][2]string) string {
+	var dataBlock []byte
+	for _, p := range pairs {
+		dataBlock = append(dataBlock, EncodeDataEntry([]byte(p[0]), []byte(p[1]))...)
+	}
+
+	lastKey := pairs[len(pairs)-1][0]
+	indexBlock := EncodeIndexEntry([]byte(lastKey), 0, uint64(len(dataBlock)))
+
+	file := append([]byte{}, dataBlock...)
+	indexOffset := uint64(len(file))
+	file = append(file, indexBlock...)
+	file = append(file, EncodeTableFooter(indexOffset, uint64(len(indexBlock)))...)
+
+	path := filepath.Join(dir, "000001.sst")
+	require.Nil(t, ioutil.WriteFile(path, file, 0600))
+	return path
+}
+
+func TestReadTableAndGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rocksdb-table")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := buildTable(t, dir, [][2]string{{"a", "1"}, {"b", "2"}, {"c", "3"}})
+
+	props, entries, err := ReadTable(path)
+	require.Nil(t, err)
+	require.Equal(t, []byte("a"), props.SmallestKey)
+	require.Equal(t, []byte("c"), props.LargestKey)
+	require.Len(t, entries, 1)
+
+	block, err := ReadBlockAt(path, entries[0].Offset, entries[0].Size)
+	require.Nil(t, err)
+	dataEntries, err := ParseDataBlock(block)
+	require.Nil(t, err)
+	require.Len(t, dataEntries, 3)
+	require.Equal(t, []byte("b"), dataEntries[1].Key)
+	require.Equal(t, []byte("2"), dataEntries[1].Value)
+}
+
+func TestTablePropertiesContains(t *testing.T) {
+	props := TableProperties{SmallestKey: []byte("b"), LargestKey: []byte("y")}
+	cmp := Comparator(func(a, bb []byte) int {
+		switch {
+		case string(a) < string(bb):
+			return -1
+		case string(a) > string(bb):
+			return 1
+		default:
+			return 0
+		}
+	})
+	require.True(t, props.Contains([]byte("m"), cmp))
+	require.False(t, props.Contains([]byte("a"), cmp))
+	require.False(t, props.Contains([]byte("z"), cmp))
+	require.False(t, (&TableProperties{}).Contains([]byte("m"), cmp))
+}
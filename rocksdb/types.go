@@ -22,7 +22,14 @@
 
 package rocksdb
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+
+	"github.com/pingcap/errors"
+)
+
+// errInvalidIndexBlock is returned by ParseIndexBlock on truncated or malformed input.
+var errInvalidIndexBlock = errors.New("invalid index block")
 
 // ValueType describes a type of a value.
 type ValueType uint8
@@ -78,6 +85,52 @@ type TableProperties struct {
 	CreationTime        uint64
 	OldestKeyTime       uint64
 	PrefixExtractorName string
+	// SmallestKey and LargestKey bound the table's key range, populated by ReadTable.
+	SmallestKey []byte
+	LargestKey  []byte
+}
+
+// Contains reports whether key, ordered by cmp, falls within [SmallestKey, LargestKey].
+func (p *TableProperties) Contains(key []byte, cmp Comparator) bool {
+	if len(p.SmallestKey) == 0 || len(p.LargestKey) == 0 {
+		return false
+	}
+	return cmp(key, p.SmallestKey) >= 0 && cmp(key, p.LargestKey) <= 0
+}
+
+// IndexEntry is a single entry of a parsed SST index block: a separator key paired with the
+// offset/size of the data block it points at.
+type IndexEntry struct {
+	Key    []byte
+	Offset uint64
+	Size   uint64
+}
+
+// ParseIndexBlock parses the restart-point-free, fully unpacked entries of an SST index block, as
+// produced by the block builder with no prefix compression. Each entry is a varint-prefixed key
+// followed by an encoded block handle.
+func ParseIndexBlock(data []byte) ([]IndexEntry, error) {
+	var entries []IndexEntry
+	for off := 0; off < len(data); {
+		keyLen, n := decodeVarint64(data[off:])
+		if n == 0 {
+			return nil, errInvalidIndexBlock
+		}
+		off += n
+		if off+int(keyLen) > len(data) {
+			return nil, errInvalidIndexBlock
+		}
+		key := data[off : off+int(keyLen)]
+		off += int(keyLen)
+		var handle blockHandle
+		n = handle.Decode(data[off:])
+		if n == 0 {
+			return nil, errInvalidIndexBlock
+		}
+		off += n
+		entries = append(entries, IndexEntry{Key: key, Offset: handle.Offset, Size: handle.Size})
+	}
+	return entries, nil
 }
 
 type blockHandle struct {
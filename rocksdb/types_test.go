@@ -0,0 +1,36 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocksdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIndexBlockRoundTrip(t *testing.T) {
+	var buf []byte
+	buf = append(buf, EncodeIndexEntry([]byte("k1"), 0, 100)...)
+	buf = append(buf, EncodeIndexEntry([]byte("k2"), 100, 50)...)
+
+	entries, err := ParseIndexBlock(buf)
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, []byte("k1"), entries[0].Key)
+	require.Equal(t, uint64(0), entries[0].Offset)
+	require.Equal(t, uint64(100), entries[0].Size)
+	require.Equal(t, []byte("k2"), entries[1].Key)
+	require.Equal(t, uint64(100), entries[1].Offset)
+	require.Equal(t, uint64(50), entries[1].Size)
+}